@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// CloudInitUserData describes the NoCloud seed data to inject into a guest
+// on first boot (SSH keys, hostname, bootstrap commands, network config).
+type CloudInitUserData struct {
+	Hostname          string   `json:"hostname,omitempty"`
+	SSHAuthorizedKeys []string `json:"ssh_authorized_keys,omitempty"`
+	RunCmd            []string `json:"runcmd,omitempty"`
+	// NetworkConfig is raw cloud-init network-config (v1 or v2) YAML, written
+	// verbatim to network-config on the config drive.
+	NetworkConfig string `json:"network_config,omitempty"`
+}
+
+// buildCloudInitISO writes user-data/meta-data/network-config under vmDir and
+// packages them into a NoCloud config-drive ISO at cloud-init.iso. It returns
+// the path to the generated ISO.
+func (vmm *VMManager) buildCloudInitISO(vmDir, vmID string, ud *CloudInitUserData) (string, error) {
+	seedDir := filepath.Join(vmDir, "cloud-init")
+	if err := os.MkdirAll(seedDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create cloud-init seed directory: %v", err)
+	}
+
+	hostname := ud.Hostname
+	if hostname == "" {
+		hostname = vmID[:8]
+	}
+
+	metaData := fmt.Sprintf("instance-id: %s\nlocal-hostname: %s\n", vmID, yamlQuote(hostname))
+	if err := os.WriteFile(filepath.Join(seedDir, "meta-data"), []byte(metaData), 0644); err != nil {
+		return "", fmt.Errorf("failed to write meta-data: %v", err)
+	}
+
+	userData := renderUserData(hostname, ud)
+	if err := os.WriteFile(filepath.Join(seedDir, "user-data"), []byte(userData), 0644); err != nil {
+		return "", fmt.Errorf("failed to write user-data: %v", err)
+	}
+
+	if ud.NetworkConfig != "" {
+		if err := os.WriteFile(filepath.Join(seedDir, "network-config"), []byte(ud.NetworkConfig), 0644); err != nil {
+			return "", fmt.Errorf("failed to write network-config: %v", err)
+		}
+	}
+
+	isoPath := filepath.Join(vmDir, "cloud-init.iso")
+	if err := buildISO9660(seedDir, isoPath, "cidata"); err != nil {
+		return "", fmt.Errorf("failed to build cloud-init ISO: %v", err)
+	}
+
+	return isoPath, nil
+}
+
+// renderUserData produces #cloud-config YAML for the fields we support.
+func renderUserData(hostname string, ud *CloudInitUserData) string {
+	var b strings.Builder
+	b.WriteString("#cloud-config\n")
+	b.WriteString(fmt.Sprintf("hostname: %s\n", yamlQuote(hostname)))
+
+	if len(ud.SSHAuthorizedKeys) > 0 {
+		b.WriteString("ssh_authorized_keys:\n")
+		for _, key := range ud.SSHAuthorizedKeys {
+			b.WriteString(fmt.Sprintf("  - %s\n", yamlQuote(key)))
+		}
+	}
+
+	if len(ud.RunCmd) > 0 {
+		b.WriteString("runcmd:\n")
+		for _, cmd := range ud.RunCmd {
+			b.WriteString(fmt.Sprintf("  - %s\n", yamlQuote(cmd)))
+		}
+	}
+
+	return b.String()
+}
+
+// yamlQuote renders s as a double-quoted YAML scalar so a value containing
+// ":", "#", a leading "-"/quote, or other flow-scalar-breaking characters
+// can't alter the structure of the generated #cloud-config document. Go's
+// %q escaping (backslash/quote escapes, UTF-8 passthrough) also produces a
+// valid YAML double-quoted scalar, so no separate YAML escaper is needed.
+func yamlQuote(s string) string {
+	return fmt.Sprintf("%q", s)
+}
+
+// buildISO9660 packages dir into an ISO9660 image at isoPath, preferring
+// genisoimage and falling back to mkisofs (both produce NoCloud-compatible
+// images when given the "cidata" volume label).
+func buildISO9660(dir, isoPath, volumeLabel string) error {
+	for _, tool := range []string{"genisoimage", "mkisofs"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			continue
+		}
+		cmd := exec.Command(tool, "-output", isoPath, "-volid", volumeLabel, "-joliet", "-rock", dir)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("%s failed: %v (output: %s)", tool, err, string(output))
+		}
+		return nil
+	}
+	return fmt.Errorf("neither genisoimage nor mkisofs found in PATH")
+}
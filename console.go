@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/firecracker-microvm/firecracker-go-sdk"
+	"github.com/gin-gonic/gin"
+)
+
+// consoleRingBufferLines bounds how many lines of console output we keep in
+// memory for the logs/stream endpoints. Every line is also appended to the
+// per-VM log file, which is not bounded or truncated.
+const consoleRingBufferLines = 2000
+
+// ConsoleBuffer ring-buffers the most recent lines of a VM's serial console
+// (ttyS0) output, tee'd to a log file under the VM directory. It implements
+// io.Writer so it can be attached directly as the Firecracker process's
+// stdout/stderr.
+type ConsoleBuffer struct {
+	mutex     sync.RWMutex
+	lines     []string
+	pending   []byte
+	logFile   *os.File
+	listeners map[chan string]struct{}
+}
+
+func newConsoleBuffer(logPath string) (*ConsoleBuffer, error) {
+	f, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open console log file: %v", err)
+	}
+	return &ConsoleBuffer{
+		logFile:   f,
+		listeners: make(map[chan string]struct{}),
+	}, nil
+}
+
+// Write implements io.Writer, splitting incoming bytes into lines and
+// appending each complete line to the ring buffer.
+func (cb *ConsoleBuffer) Write(p []byte) (int, error) {
+	cb.mutex.Lock()
+	cb.pending = append(cb.pending, p...)
+	for {
+		idx := indexByte(cb.pending, '\n')
+		if idx < 0 {
+			break
+		}
+		cb.appendLineLocked(string(cb.pending[:idx]))
+		cb.pending = cb.pending[idx+1:]
+	}
+	cb.mutex.Unlock()
+	return len(p), nil
+}
+
+func (cb *ConsoleBuffer) appendLineLocked(line string) {
+	cb.lines = append(cb.lines, line)
+	if len(cb.lines) > consoleRingBufferLines {
+		cb.lines = cb.lines[len(cb.lines)-consoleRingBufferLines:]
+	}
+	for ch := range cb.listeners {
+		select {
+		case ch <- line:
+		default:
+		}
+	}
+	fmt.Fprintln(cb.logFile, line)
+}
+
+func indexByte(b []byte, c byte) int {
+	for i, v := range b {
+		if v == c {
+			return i
+		}
+	}
+	return -1
+}
+
+func (cb *ConsoleBuffer) tail(n int) []string {
+	cb.mutex.RLock()
+	defer cb.mutex.RUnlock()
+
+	if n <= 0 || n > len(cb.lines) {
+		n = len(cb.lines)
+	}
+	out := make([]string, n)
+	copy(out, cb.lines[len(cb.lines)-n:])
+	return out
+}
+
+func (cb *ConsoleBuffer) subscribe() chan string {
+	ch := make(chan string, 64)
+	cb.mutex.Lock()
+	cb.listeners[ch] = struct{}{}
+	cb.mutex.Unlock()
+	return ch
+}
+
+func (cb *ConsoleBuffer) unsubscribe(ch chan string) {
+	cb.mutex.Lock()
+	delete(cb.listeners, ch)
+	cb.mutex.Unlock()
+	close(ch)
+}
+
+func (cb *ConsoleBuffer) close() {
+	cb.logFile.Close()
+}
+
+func (vmm *VMManager) consoleLogPath(vmID string) string {
+	return filepath.Join(vmm.config.VMDir, vmID, "console.log")
+}
+
+// attachConsole wires a VM's serial console (ttyS0) to a ring-buffered log so
+// operators can debug guest boot failures without SSH. It registers the
+// buffer under vm.ID and returns a firecracker.Opt that pipes the
+// Firecracker process's stdout/stderr into it; call this before NewMachine.
+//
+// The SDK only builds its own jailer-wrapped exec.Cmd (and threads
+// cfg.SocketPath into its argv) when Machine.Cmd is still unset once options
+// have run. Since WithProcessRunner here always sets Cmd, this function must
+// build an equivalent command itself - including passing socketPath through
+// explicitly - or the spawned Firecracker process never opens its API socket
+// where m.client expects to dial it. A jailed VM's process is built through
+// JailerCommandBuilder (with the console buffer as stdout/stderr) rather
+// than the plain VMCommandBuilder used for unjailed VMs, since that's also
+// the only way to get cfg.JailerCfg applied at all.
+func (vmm *VMManager) attachConsole(vm *VM, cfg *Config, socketPath string) (firecracker.Opt, error) {
+	cb, err := newConsoleBuffer(vmm.consoleLogPath(vm.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	vmm.consoleMutex.Lock()
+	vmm.consoles[vm.ID] = cb
+	vmm.consoleMutex.Unlock()
+
+	var cmd *exec.Cmd
+	if vm.Jailed {
+		// prepareJail stages the filter at the jail root under this name.
+		fcArgs := []string{"--api-sock", socketPath}
+		if seccompFilterPath(vm, cfg) != "" {
+			fcArgs = append(fcArgs, "--seccomp-filter", "seccomp-filter.bpf")
+		}
+		cmd = firecracker.JailerCommandBuilder{}.
+			WithID(vm.ID).
+			WithUID(vm.UID).
+			WithGID(vm.GID).
+			WithNumaNode(0).
+			WithExecFile(cfg.FirecrackerBinary).
+			WithBin(cfg.JailerBinary).
+			WithChrootBaseDir(cfg.ChrootBaseDir).
+			WithFirecrackerArgs(fcArgs...).
+			WithStdout(cb).
+			WithStderr(cb).
+			Build(context.Background())
+	} else {
+		cmd = firecracker.VMCommandBuilder{}.
+			WithSocketPath(socketPath).
+			WithStdout(cb).
+			WithStderr(cb).
+			Build(context.Background())
+	}
+
+	return firecracker.WithProcessRunner(cmd), nil
+}
+
+func (vmm *VMManager) detachConsole(vmID string) {
+	vmm.consoleMutex.Lock()
+	cb, ok := vmm.consoles[vmID]
+	if ok {
+		delete(vmm.consoles, vmID)
+	}
+	vmm.consoleMutex.Unlock()
+
+	if ok {
+		cb.close()
+	}
+}
+
+// consoleLogsHandler returns the last N lines (default 200) of a VM's
+// captured serial console output.
+func (vmm *VMManager) consoleLogsHandler(c *gin.Context) {
+	vmID := c.Param("id")
+
+	vmm.consoleMutex.RLock()
+	cb, ok := vmm.consoles[vmID]
+	vmm.consoleMutex.RUnlock()
+	if !ok {
+		c.JSON(http.StatusNotFound, Response{
+			Success: false,
+			Message: "no console output available for this VM",
+		})
+		return
+	}
+
+	lines := 200
+	if raw := c.Query("lines"); raw != "" {
+		if n, err := parsePositiveInt(raw); err == nil {
+			lines = n
+		}
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Success: true,
+		Message: "console logs retrieved successfully",
+		Data:    cb.tail(lines),
+	})
+}
+
+// consoleStreamHandler tails a VM's console as chunked, newline-delimited
+// HTTP output so operators can follow guest boot without SSH.
+func (vmm *VMManager) consoleStreamHandler(c *gin.Context) {
+	vmID := c.Param("id")
+
+	vmm.consoleMutex.RLock()
+	cb, ok := vmm.consoles[vmID]
+	vmm.consoleMutex.RUnlock()
+	if !ok {
+		c.JSON(http.StatusNotFound, Response{
+			Success: false,
+			Message: "no console output available for this VM",
+		})
+		return
+	}
+
+	ch := cb.subscribe()
+	defer cb.unsubscribe(ch)
+
+	c.Status(http.StatusOK)
+	c.Header("Content-Type", "text/plain")
+	c.Header("X-Content-Type-Options", "nosniff")
+
+	flusher, canFlush := c.Writer.(http.Flusher)
+
+	ctx := c.Request.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case line, open := <-ch:
+			if !open {
+				return
+			}
+			fmt.Fprintln(c.Writer, line)
+			if canFlush {
+				flusher.Flush()
+			}
+		case <-time.After(30 * time.Second):
+			// Periodic keep-alive so idle proxies don't close the connection.
+			fmt.Fprint(c.Writer, "\n")
+			if canFlush {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+func parsePositiveInt(s string) (int, error) {
+	n := 0
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return 0, fmt.Errorf("invalid integer: %s", s)
+		}
+		n = n*10 + int(r-'0')
+	}
+	if n <= 0 {
+		return 0, fmt.Errorf("must be positive: %s", s)
+	}
+	return n, nil
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/mount"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/gin-gonic/gin"
+	digest "github.com/opencontainers/go-digest"
+)
+
+// ImageImportRequest describes an OCI image to pull and convert into a
+// Firecracker-bootable rootfs.
+type ImageImportRequest struct {
+	Name string `json:"name" binding:"required"`
+	Ref  string `json:"ref" binding:"required"` // e.g. docker.io/library/alpine:3.19
+}
+
+// validateImageName rejects image names that aren't a plain file basename,
+// since Import and CreateVM both interpolate it directly into paths under
+// BaseImagesDir (<name>.ext4, <name>.json) - an unvalidated "../../etc/passwd"
+// would let a caller write or read outside that directory.
+func validateImageName(name string) error {
+	if name == "" {
+		return fmt.Errorf("image name must not be empty")
+	}
+	if name != filepath.Base(name) || name == "." || name == ".." {
+		return fmt.Errorf("invalid image name %q: must not contain path separators", name)
+	}
+	return nil
+}
+
+// ImageMetadata is the JSON sidecar recorded alongside each imported rootfs
+// so GET /api/v1/images can report provenance without re-inspecting the
+// image.
+type ImageMetadata struct {
+	Name       string    `json:"name"`
+	Ref        string    `json:"ref"`
+	Digest     string    `json:"digest"`
+	Entrypoint []string  `json:"entrypoint,omitempty"`
+	Cmd        []string  `json:"cmd,omitempty"`
+	Env        []string  `json:"env,omitempty"`
+	ImportedAt time.Time `json:"imported_at"`
+}
+
+// ImageBuilder pulls OCI images via containerd's content store and packages
+// their root filesystem into ext4 images under BaseImagesDir for CreateVM to
+// reference by name.
+type ImageBuilder struct {
+	client        *containerd.Client
+	baseImagesDir string
+	namespace     string
+}
+
+func NewImageBuilder(baseImagesDir, containerdSocket string) (*ImageBuilder, error) {
+	client, err := containerd.New(containerdSocket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to containerd at %s: %v", containerdSocket, err)
+	}
+
+	return &ImageBuilder{
+		client:        client,
+		baseImagesDir: baseImagesDir,
+		namespace:     "firecracker-vps",
+	}, nil
+}
+
+// Import pulls ref, unpacks its layered filesystem into a scratch directory,
+// seeds /etc/hostname and /etc/resolv.conf, writes an init script that execs
+// the image's Entrypoint+Cmd, and packages the tree into <name>.ext4 under
+// BaseImagesDir. It records an ImageMetadata sidecar next to the ext4 image.
+func (ib *ImageBuilder) Import(req ImageImportRequest) (*ImageMetadata, error) {
+	if err := validateImageName(req.Name); err != nil {
+		return nil, err
+	}
+
+	ctx := namespaces.WithNamespace(context.Background(), ib.namespace)
+
+	image, err := ib.client.Pull(ctx, req.Ref, containerd.WithPullUnpack)
+	if err != nil {
+		return nil, fmt.Errorf("failed to pull image %s: %v", req.Ref, err)
+	}
+
+	spec, err := image.Spec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image config for %s: %v", req.Ref, err)
+	}
+
+	scratchDir, err := os.MkdirTemp("", fmt.Sprintf("image-import-%s-*", req.Name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create scratch directory: %v", err)
+	}
+	defer os.RemoveAll(scratchDir)
+
+	if err := ib.unpackRootfs(ctx, image, scratchDir); err != nil {
+		return nil, fmt.Errorf("failed to unpack rootfs: %v", err)
+	}
+
+	entrypoint, cmd, env := spec.Config.Entrypoint, spec.Config.Cmd, spec.Config.Env
+
+	if err := writeGuestFiles(scratchDir, req.Name, entrypoint, cmd, env); err != nil {
+		return nil, fmt.Errorf("failed to write guest files: %v", err)
+	}
+
+	if err := os.MkdirAll(ib.baseImagesDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create base images directory: %v", err)
+	}
+
+	ext4Path := filepath.Join(ib.baseImagesDir, req.Name+".ext4")
+	if err := packExt4(scratchDir, ext4Path); err != nil {
+		return nil, fmt.Errorf("failed to package ext4 image: %v", err)
+	}
+
+	meta := &ImageMetadata{
+		Name:       req.Name,
+		Ref:        req.Ref,
+		Digest:     image.Target().Digest.String(),
+		Entrypoint: entrypoint,
+		Cmd:        cmd,
+		Env:        env,
+		ImportedAt: time.Now(),
+	}
+	if err := writeImageMetadata(ib.baseImagesDir, meta); err != nil {
+		return nil, fmt.Errorf("failed to write image metadata: %v", err)
+	}
+
+	return meta, nil
+}
+
+// unpackRootfs materializes the image's layered filesystem as a plain
+// directory tree at destDir. It unpacks the image into containerd's
+// snapshotter, temporarily mounts the resulting snapshot, and copies its
+// contents out so the tree can be packaged independently of containerd.
+func (ib *ImageBuilder) unpackRootfs(ctx context.Context, image containerd.Image, destDir string) error {
+	if err := image.Unpack(ctx, containerd.DefaultSnapshotter); err != nil {
+		return fmt.Errorf("failed to unpack image layers: %v", err)
+	}
+
+	diffIDs, err := image.RootFS(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to resolve rootfs diff IDs: %v", err)
+	}
+
+	snapshotter := ib.client.SnapshotService(containerd.DefaultSnapshotter)
+	mounts, err := snapshotter.Mounts(ctx, layerChainID(diffIDs).String())
+	if err != nil {
+		return fmt.Errorf("failed to resolve snapshot mounts: %v", err)
+	}
+
+	return mount.WithTempMount(ctx, mounts, func(mountpoint string) error {
+		return copyTree(mountpoint, destDir)
+	})
+}
+
+// layerChainID computes the OCI chain ID for a sequence of layer diff IDs:
+// chain(layer[0]) = diffID[0], chain(layer[n]) = sha256(chain(layer[n-1]) + " " + diffID[n]).
+func layerChainID(diffIDs []digest.Digest) digest.Digest {
+	if len(diffIDs) == 0 {
+		return ""
+	}
+
+	chain := diffIDs[0]
+	for _, diffID := range diffIDs[1:] {
+		sum := sha256.Sum256([]byte(chain.String() + " " + diffID.String()))
+		chain = digest.Digest("sha256:" + hex.EncodeToString(sum[:]))
+	}
+	return chain
+}
+
+// writeGuestFiles seeds the minimal set of files a guest needs to boot
+// standalone: hostname, resolver config, and an init script that execs the
+// image's entrypoint/cmd as PID 1.
+func writeGuestFiles(rootDir, hostname string, entrypoint, cmd, env []string) error {
+	if err := os.MkdirAll(filepath.Join(rootDir, "etc"), 0755); err != nil {
+		return fmt.Errorf("failed to create /etc: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(rootDir, "sbin"), 0755); err != nil {
+		return fmt.Errorf("failed to create /sbin: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(rootDir, "etc", "hostname"), []byte(hostname+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write /etc/hostname: %v", err)
+	}
+
+	resolvConf := "nameserver 8.8.8.8\nnameserver 1.1.1.1\n"
+	if err := os.WriteFile(filepath.Join(rootDir, "etc", "resolv.conf"), []byte(resolvConf), 0644); err != nil {
+		return fmt.Errorf("failed to write /etc/resolv.conf: %v", err)
+	}
+
+	args := append(append([]string{}, entrypoint...), cmd...)
+	initScript := "#!/bin/sh\nset -e\n"
+	for _, e := range env {
+		initScript += fmt.Sprintf("export %s\n", e)
+	}
+	initScript += fmt.Sprintf("exec %s\n", shellJoin(args))
+
+	initPath := filepath.Join(rootDir, "sbin", "init")
+	if err := os.WriteFile(initPath, []byte(initScript), 0755); err != nil {
+		return fmt.Errorf("failed to write /sbin/init: %v", err)
+	}
+
+	return nil
+}
+
+func shellJoin(args []string) string {
+	joined := ""
+	for i, a := range args {
+		if i > 0 {
+			joined += " "
+		}
+		joined += fmt.Sprintf("%q", a)
+	}
+	return joined
+}
+
+// packExt4 creates an ext4 filesystem image at ext4Path sized to fit dir's
+// contents and populates it via mkfs.ext4 -d, which writes the tree directly
+// without requiring a loop-mounted intermediate.
+func packExt4(dir, ext4Path string) error {
+	sizeBytes, err := dirSize(dir)
+	if err != nil {
+		return fmt.Errorf("failed to measure rootfs size: %v", err)
+	}
+	// Leave headroom for filesystem metadata.
+	sizeMB := sizeBytes/(1024*1024) + 256
+
+	truncateCmd := exec.Command("truncate", "-s", fmt.Sprintf("%dM", sizeMB), ext4Path)
+	if output, err := truncateCmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("truncate failed: %v (output: %s)", err, string(output))
+	}
+
+	mkfsCmd := exec.Command("mkfs.ext4", "-F", "-d", dir, ext4Path)
+	if output, err := mkfsCmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("mkfs.ext4 failed: %v (output: %s)", err, string(output))
+	}
+
+	return nil
+}
+
+// copyTree recursively copies src into dst, preserving file modes. It shells
+// out to cp -a rather than reimplementing a directory walk, since the
+// snapshot mount may contain device nodes and other special files that
+// io.Copy can't reproduce.
+func copyTree(src, dst string) error {
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return fmt.Errorf("failed to create destination tree %s: %v", dst, err)
+	}
+
+	cmd := exec.Command("cp", "-a", src+"/.", dst+"/")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("cp -a failed: %v (output: %s)", err, string(output))
+	}
+	return nil
+}
+
+func dirSize(dir string) (int64, error) {
+	var total int64
+	err := filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total, err
+}
+
+func writeImageMetadata(baseImagesDir string, meta *ImageMetadata) error {
+	sidecarPath := filepath.Join(baseImagesDir, meta.Name+".json")
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(sidecarPath, data, 0644)
+}
+
+// ListImages reads the JSON sidecars under BaseImagesDir.
+func (ib *ImageBuilder) ListImages() ([]*ImageMetadata, error) {
+	entries, err := os.ReadDir(ib.baseImagesDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []*ImageMetadata{}, nil
+		}
+		return nil, err
+	}
+
+	images := make([]*ImageMetadata, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(ib.baseImagesDir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		var meta ImageMetadata
+		if err := json.Unmarshal(data, &meta); err != nil {
+			continue
+		}
+		images = append(images, &meta)
+	}
+	return images, nil
+}
+
+// API Handlers
+
+func (vmm *VMManager) importImageHandler(c *gin.Context) {
+	var req ImageImportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Success: false,
+			Message: fmt.Sprintf("Invalid request: %v", err),
+		})
+		return
+	}
+
+	if vmm.imageBuilder == nil {
+		c.JSON(http.StatusServiceUnavailable, Response{
+			Success: false,
+			Message: "image importer is not configured (set CONTAINERD_SOCKET)",
+		})
+		return
+	}
+
+	meta, err := vmm.imageBuilder.Import(req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Success: false,
+			Message: fmt.Sprintf("Failed to import image: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, Response{
+		Success: true,
+		Message: "image imported successfully",
+		Data:    meta,
+	})
+}
+
+func (vmm *VMManager) listImagesHandler(c *gin.Context) {
+	if vmm.imageBuilder == nil {
+		c.JSON(http.StatusOK, Response{
+			Success: true,
+			Message: "images retrieved successfully",
+			Data:    []*ImageMetadata{},
+		})
+		return
+	}
+
+	images, err := vmm.imageBuilder.ListImages()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Success: false,
+			Message: fmt.Sprintf("Failed to list images: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Success: true,
+		Message: "images retrieved successfully",
+		Data:    images,
+	})
+}
@@ -24,62 +24,80 @@ import (
 
 // VM represents a virtual machine instance
 type VM struct {
-	ID         string    `json:"id"`
-	Name       string    `json:"name"`
-	CPU        int       `json:"cpu"`
-	Memory     int       `json:"memory"`    // MB
-	DiskSize   int       `json:"disk_size"` // GB
-	Image      string    `json:"image"`
-	Status     string    `json:"status"`
-	IPAddress  string    `json:"ip_address"`
-	CreatedAt  time.Time `json:"created_at"`
-	SocketPath string    `json:"socket_path"`
-	KernelPath string    `json:"kernel_path"`
-	RootfsPath string    `json:"rootfs_path"`
-	TapDevice  string    `json:"tap_device"`
-	machine    *firecracker.Machine
+	ID               string          `json:"id"`
+	Name             string          `json:"name"`
+	CPU              int             `json:"cpu"`
+	Memory           int             `json:"memory"`    // MB
+	DiskSize         int             `json:"disk_size"` // GB
+	Image            string          `json:"image"`
+	Status           string          `json:"status"`
+	IPAddress        string          `json:"ip_address"`
+	Network          string          `json:"network"`
+	CreatedAt        time.Time       `json:"created_at"`
+	SocketPath       string          `json:"socket_path"`
+	KernelPath       string          `json:"kernel_path"`
+	RootfsPath       string          `json:"rootfs_path"`
+	CloudInitISOPath string          `json:"cloud_init_iso_path,omitempty"`
+	PortForwards     []PortMapping   `json:"port_forwards,omitempty"`
+	Snapshots        []Snapshot      `json:"snapshots,omitempty"`
+	RestartPolicy    string          `json:"restart_policy,omitempty"`
+	Jailed           bool            `json:"jailed,omitempty"`
+	UID              int             `json:"uid,omitempty"`
+	GID              int             `json:"gid,omitempty"`
+	Security         *SecurityConfig `json:"security,omitempty"`
+	machine          *firecracker.Machine
 }
 
 // VMRequest represents a VM creation request
 type VMRequest struct {
-	Name     string `json:"name" binding:"required"`
-	CPU      int    `json:"cpu" binding:"required,min=1,max=8"`
-	Memory   int    `json:"memory" binding:"required,min=128,max=8192"`
-	DiskSize int    `json:"disk_size" binding:"required,min=1,max=100"`
-	Image    string `json:"image" binding:"required"`
+	Name          string             `json:"name" binding:"required"`
+	CPU           int                `json:"cpu" binding:"required,min=1,max=8"`
+	Memory        int                `json:"memory" binding:"required,min=128,max=8192"`
+	DiskSize      int                `json:"disk_size" binding:"required,min=1,max=100"`
+	Image         string             `json:"image" binding:"required"`
+	UserData      *CloudInitUserData `json:"user_data,omitempty"`
+	Network       string             `json:"network,omitempty"` // named network from networks.d, defaults to "default"
+	PortForwards  []PortMapping      `json:"port_forwards,omitempty"`
+	RestartPolicy string             `json:"restart_policy,omitempty"` // "" or "always"
+	Security      *SecurityConfig    `json:"security,omitempty"`       // jailer sandboxing, defaults to Config.JailerEnabled
 }
 
 // VMManager manages all VM instances
 type VMManager struct {
-	vms        map[string]*VM
-	mutex      sync.RWMutex
-	config     *Config
-	ipPool     *IPPool
-	tapManager *TapManager
-}
+	vms            map[string]*VM
+	mutex          sync.RWMutex
+	config         *Config
+	networkManager *NetworkManager
+	store          *VMStore
 
-// Config holds application configuration
-type Config struct {
-	APIPort       string
-	VMDir         string
-	KernelPath    string
-	BaseImagesDir string
-	NetworkBridge string
-	NetworkSubnet string
-	MaxVMsPerHost int
-}
+	consoles     map[string]*ConsoleBuffer
+	consoleMutex sync.RWMutex
 
-// IPPool manages IP address allocation
-type IPPool struct {
-	subnet    string
-	allocated map[string]bool
-	mutex     sync.RWMutex
+	imageBuilder    *ImageBuilder
+	uidGidAllocator *UIDGIDAllocator
 }
 
-// TapManager manages TAP network interfaces
-type TapManager struct {
-	tapDevices map[string]bool
-	mutex      sync.RWMutex
+// Config holds application configuration
+type Config struct {
+	APIPort          string
+	VMDir            string
+	KernelPath       string
+	BaseImagesDir    string
+	NetworksDir      string
+	CNIBinDir        string
+	MaxVMsPerHost    int
+	ContainerdSocket string
+	StatePath        string
+
+	// Jailer sandboxing: per-VM UID/GID, chroot, and cgroup v2 limits.
+	JailerEnabled     bool
+	JailerBinary      string
+	FirecrackerBinary string
+	ChrootBaseDir     string
+	CgroupRoot        string
+	SeccompFilterPath string
+	UIDGIDRangeStart  int
+	UIDGIDRangeEnd    int
 }
 
 // Response represents API response structure
@@ -91,13 +109,24 @@ type Response struct {
 
 func NewConfig() *Config {
 	return &Config{
-		APIPort:       getEnvOrDefault("API_PORT", "8080"),
-		VMDir:         getEnvOrDefault("VM_DIR", "/var/lib/firecracker-vms"),
-		KernelPath:    getEnvOrDefault("KERNEL_PATH", "/var/lib/firecracker/vmlinux.bin"),
-		BaseImagesDir: getEnvOrDefault("BASE_IMAGES_DIR", "/var/lib/firecracker/images"),
-		NetworkBridge: getEnvOrDefault("NETWORK_BRIDGE", "br0"),
-		NetworkSubnet: getEnvOrDefault("NETWORK_SUBNET", "192.168.100.0/24"),
-		MaxVMsPerHost: getEnvInt("MAX_VMS_PER_HOST", 100),
+		APIPort:          getEnvOrDefault("API_PORT", "8080"),
+		VMDir:            getEnvOrDefault("VM_DIR", "/var/lib/firecracker-vms"),
+		KernelPath:       getEnvOrDefault("KERNEL_PATH", "/var/lib/firecracker/vmlinux.bin"),
+		BaseImagesDir:    getEnvOrDefault("BASE_IMAGES_DIR", "/var/lib/firecracker/images"),
+		NetworksDir:      getEnvOrDefault("NETWORKS_DIR", "/etc/firecracker/networks.d"),
+		CNIBinDir:        getEnvOrDefault("CNI_BIN_DIR", "/opt/cni/bin"),
+		MaxVMsPerHost:    getEnvInt("MAX_VMS_PER_HOST", 100),
+		ContainerdSocket: getEnvOrDefault("CONTAINERD_SOCKET", "/run/containerd/containerd.sock"),
+		StatePath:        getEnvOrDefault("STATE_PATH", "/var/lib/firecracker-vms/state.db"),
+
+		JailerEnabled:     getEnvOrDefault("JAILER_ENABLED", "false") == "true",
+		JailerBinary:      getEnvOrDefault("JAILER_BINARY", "/usr/bin/jailer"),
+		FirecrackerBinary: getEnvOrDefault("FIRECRACKER_BINARY", "/usr/bin/firecracker"),
+		ChrootBaseDir:     getEnvOrDefault("CHROOT_BASE_DIR", "/var/lib/firecracker"),
+		CgroupRoot:        getEnvOrDefault("CGROUP_ROOT", "/sys/fs/cgroup"),
+		SeccompFilterPath: getEnvOrDefault("SECCOMP_FILTER_PATH", "/etc/firecracker/seccomp.json"),
+		UIDGIDRangeStart:  getEnvInt("UID_GID_RANGE_START", 10000),
+		UIDGIDRangeEnd:    getEnvInt("UID_GID_RANGE_END", 20000),
 	}
 }
 
@@ -117,85 +146,50 @@ func getEnvInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
-func NewVMManager(config *Config) *VMManager {
-	return &VMManager{
-		vms:        make(map[string]*VM),
-		config:     config,
-		ipPool:     NewIPPool(config.NetworkSubnet),
-		tapManager: NewTapManager(),
+func NewVMManager(config *Config) (*VMManager, error) {
+	store, err := NewVMStore(config.StatePath)
+	if err != nil {
+		return nil, err
 	}
-}
 
-func NewIPPool(subnet string) *IPPool {
-	return &IPPool{
-		subnet:    subnet,
-		allocated: make(map[string]bool),
+	vmm := &VMManager{
+		vms:             make(map[string]*VM),
+		config:          config,
+		networkManager:  NewNetworkManager(config.NetworksDir, []string{config.CNIBinDir}),
+		consoles:        make(map[string]*ConsoleBuffer),
+		store:           store,
+		uidGidAllocator: NewUIDGIDAllocator(config.UIDGIDRangeStart, config.UIDGIDRangeEnd),
 	}
-}
 
-func NewTapManager() *TapManager {
-	return &TapManager{
-		tapDevices: make(map[string]bool),
+	if err := vmm.reloadVMs(); err != nil {
+		return nil, err
 	}
-}
-
-func (ip *IPPool) AllocateIP() string {
-	ip.mutex.Lock()
-	defer ip.mutex.Unlock()
 
-	// Simple IP allocation - in production, use proper CIDR calculation
-	for i := 10; i < 254; i++ {
-		ipAddr := fmt.Sprintf("192.168.100.%d", i)
-		if !ip.allocated[ipAddr] {
-			ip.allocated[ipAddr] = true
-			return ipAddr
-		}
-	}
-	return ""
-}
-
-func (ip *IPPool) ReleaseIP(ipAddr string) {
-	ip.mutex.Lock()
-	defer ip.mutex.Unlock()
-	delete(ip.allocated, ipAddr)
-}
-
-func (tm *TapManager) AllocateTap(vmID string) string {
-	tm.mutex.Lock()
-	defer tm.mutex.Unlock()
-
-	tapName := fmt.Sprintf("tap-%s", vmID[:8])
-	tm.tapDevices[tapName] = true
-	return tapName
-}
-
-func (tm *TapManager) ReleaseTap(tapName string) {
-	tm.mutex.Lock()
-	defer tm.mutex.Unlock()
-	delete(tm.tapDevices, tapName)
+	return vmm, nil
 }
 
 func (vmm *VMManager) CreateVM(req VMRequest) (*VM, error) {
+	if err := validateImageName(req.Image); err != nil {
+		return nil, err
+	}
+
 	vmm.mutex.Lock()
 	defer vmm.mutex.Unlock()
 
 	// Generate unique VM ID
 	vmID := uuid.New().String()
 
-	// Allocate resources
-	ipAddr := vmm.ipPool.AllocateIP()
-	if ipAddr == "" {
-		return nil, fmt.Errorf("no available IP addresses")
+	networkName := req.Network
+	if networkName == "" {
+		networkName = "default"
+	}
+	if !vmm.networkManager.NetworkExists(networkName) {
+		return nil, fmt.Errorf("network %q not found under %s", networkName, vmm.config.NetworksDir)
 	}
-
-	tapDevice := vmm.tapManager.AllocateTap(vmID)
-	logrus.Info("Tap device allocated: ", tapDevice)
 
 	// Create VM directory
 	vmDir := filepath.Join(vmm.config.VMDir, vmID)
 	if err := os.MkdirAll(vmDir, 0755); err != nil {
-		vmm.ipPool.ReleaseIP(ipAddr)
-		vmm.tapManager.ReleaseTap(tapDevice)
 		return nil, fmt.Errorf("failed to create VM directory: %v", err)
 	}
 
@@ -204,33 +198,54 @@ func (vmm *VMManager) CreateVM(req VMRequest) (*VM, error) {
 	rootfsPath := filepath.Join(vmDir, "rootfs.ext4")
 
 	if err := vmm.createVMRootfs(baseImagePath, rootfsPath, req.DiskSize); err != nil {
-		vmm.cleanup(vmID, ipAddr, tapDevice)
+		os.RemoveAll(vmDir)
 		return nil, fmt.Errorf("failed to create VM rootfs: %v", err)
 	}
 
-	// Create TAP interface
-	if err := vmm.createTapInterface(tapDevice); err != nil {
-		vmm.cleanup(vmID, ipAddr, tapDevice)
-		return nil, fmt.Errorf("failed to create TAP interface: %v", err)
+	jailed := vmm.config.JailerEnabled
+	if req.Security != nil {
+		jailed = req.Security.Jailer
 	}
 
 	vm := &VM{
-		ID:         vmID,
-		Name:       req.Name,
-		CPU:        req.CPU,
-		Memory:     req.Memory,
-		DiskSize:   req.DiskSize,
-		Image:      req.Image,
-		Status:     "created",
-		IPAddress:  ipAddr,
-		CreatedAt:  time.Now(),
-		SocketPath: filepath.Join(vmDir, "firecracker.socket"),
-		KernelPath: vmm.config.KernelPath,
-		RootfsPath: rootfsPath,
-		TapDevice:  tapDevice,
+		ID:            vmID,
+		Name:          req.Name,
+		CPU:           req.CPU,
+		Memory:        req.Memory,
+		DiskSize:      req.DiskSize,
+		Image:         req.Image,
+		Status:        "created",
+		Network:       networkName,
+		CreatedAt:     time.Now(),
+		SocketPath:    filepath.Join(vmDir, "firecracker.socket"),
+		KernelPath:    vmm.config.KernelPath,
+		RootfsPath:    rootfsPath,
+		PortForwards:  req.PortForwards,
+		RestartPolicy: req.RestartPolicy,
+		Jailed:        jailed,
+		Security:      req.Security,
+	}
+
+	if err := vmm.allocateJailerUIDGID(vm); err != nil {
+		os.RemoveAll(vmDir)
+		return nil, err
+	}
+
+	// Materialize a NoCloud config-drive ISO when the caller supplied
+	// first-boot provisioning data.
+	if req.UserData != nil {
+		isoPath, err := vmm.buildCloudInitISO(vmDir, vmID, req.UserData)
+		if err != nil {
+			os.RemoveAll(vmDir)
+			return nil, fmt.Errorf("failed to build cloud-init ISO: %v", err)
+		}
+		vm.CloudInitISOPath = isoPath
 	}
 
 	vmm.vms[vmID] = vm
+	if err := vmm.store.Save(vm); err != nil {
+		logrus.Warnf("failed to persist VM %s: %v", vmID, err)
+	}
 	return vm, nil
 }
 
@@ -247,47 +262,116 @@ func (vmm *VMManager) StartVM(vmID string) error {
 		return fmt.Errorf("VM is already running")
 	}
 
-	// Configure Firecracker
-	cfg := firecracker.Config{
-		SocketPath:      vm.SocketPath,
+	drives := []models.Drive{
+		{
+			DriveID:      firecracker.String("rootfs"),
+			PathOnHost:   firecracker.String(vm.RootfsPath),
+			IsRootDevice: firecracker.Bool(true),
+			IsReadOnly:   firecracker.Bool(false),
+		},
+	}
+	if vm.CloudInitISOPath != "" {
+		drives = append(drives, models.Drive{
+			DriveID:      firecracker.String("cloud-init"),
+			PathOnHost:   firecracker.String(vm.CloudInitISOPath),
+			IsRootDevice: firecracker.Bool(false),
+			IsReadOnly:   firecracker.Bool(true),
+		})
+	}
+
+	m, err := vmm.startMachine(context.Background(), vm, startMachineOpts{
 		KernelImagePath: vm.KernelPath,
 		KernelArgs:      "console=ttyS0 reboot=k panic=1 pci=off",
-		Drives: []models.Drive{
-			{
-				DriveID:      firecracker.String("rootfs"),
-				PathOnHost:   firecracker.String(vm.RootfsPath),
-				IsRootDevice: firecracker.Bool(true),
-				IsReadOnly:   firecracker.Bool(false),
-			},
-		},
+		Drives:          drives,
+	})
+	if err != nil {
+		return err
+	}
+
+	vm.machine = m
+	vm.Status = "running"
+	if err := vmm.store.Save(vm); err != nil {
+		logrus.Warnf("failed to persist VM %s: %v", vmID, err)
+	}
+
+	return nil
+}
+
+// startMachineOpts carries the pieces of firecracker.Config that differ
+// between a fresh boot (StartVM) and a snapshot-resume boot (RestoreSnapshot,
+// CloneFromSnapshot): the kernel image (absent when resuming from a
+// snapshot), the drives, and the snapshot config itself.
+type startMachineOpts struct {
+	KernelImagePath string
+	KernelArgs      string
+	Drives          []models.Drive
+	Snapshot        firecracker.SnapshotConfig
+}
+
+// startMachine builds and starts a firecracker.Machine for vm, wiring in CNI
+// networking, console capture, and jailer sandboxing the same way for every
+// VM lifecycle path. It's shared by StartVM, RestoreSnapshot, and
+// CloneFromSnapshot so those paths can't drift out of sync with each other
+// on security or observability. It does not touch vmm.vms, so callers may
+// call it with or without vmm.mutex held; they still own setting
+// vm.machine/vm.Status and persisting the result afterward.
+func (vmm *VMManager) startMachine(ctx context.Context, vm *VM, opts startMachineOpts) (*firecracker.Machine, error) {
+	socketPath := vm.SocketPath
+	kernelPath := opts.KernelImagePath
+	drives := opts.Drives
+
+	var jailerCfg *firecracker.JailerConfig
+	if vm.Jailed {
+		kernelInChroot, rootfsInChroot, socketInChroot, isoInChroot, err := prepareJail(vm, vmm.config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to prepare jail: %v", err)
+		}
+		if kernelPath != "" {
+			kernelPath = kernelInChroot
+		}
+		socketPath = socketInChroot
+
+		drives = append([]models.Drive(nil), drives...)
+		for i := range drives {
+			if drives[i].DriveID == nil {
+				continue
+			}
+			switch *drives[i].DriveID {
+			case "rootfs":
+				drives[i].PathOnHost = firecracker.String(rootfsInChroot)
+			case "cloud-init":
+				if isoInChroot != "" {
+					drives[i].PathOnHost = firecracker.String(isoInChroot)
+				}
+			}
+		}
+
+		cfg := buildJailerConfig(vm, vmm.config)
+		jailerCfg = &cfg
+
+		// Firecracker's socket path is relative to the jail root, but the
+		// reconciler and anything else probing the socket from outside the
+		// chroot needs the fully resolved host path. Persist that instead of
+		// the pre-jail path the VM was created/restored with.
+		vm.SocketPath = filepath.Join(jailerChrootDir(vmm.config.ChrootBaseDir, vmm.config.FirecrackerBinary, vm.ID), socketInChroot)
+	}
+
+	cfg := firecracker.Config{
+		SocketPath:      socketPath,
+		KernelImagePath: kernelPath,
+		KernelArgs:      opts.KernelArgs,
+		Drives:          drives,
 		NetworkInterfaces: []firecracker.NetworkInterface{{
-			CNIConfiguration: &firecracker.CNIConfiguration{
-				NetworkName: "default",
-				IfName:      "eth0",
-			},
-			StaticConfiguration: &firecracker.StaticNetworkConfiguration{
-				MacAddress:  generateMacAddress(),
-				HostDevName: vm.TapDevice,
-			},
+			CNIConfiguration: vmm.networkManager.cniConfiguration(vm.ID, vm.Network),
 		}},
 		MachineCfg: models.MachineConfiguration{
 			VcpuCount:  firecracker.Int64(int64(vm.CPU)),
 			MemSizeMib: firecracker.Int64(int64(vm.Memory)),
 		},
-		// JailerCfg: &firecracker.JailerConfig{
-		// 	GID:           firecracker.Int(1000),
-		// 	UID:           firecracker.Int(1000),
-		// 	ID:            vmID,
-		// 	NumaNode:      firecracker.Int(0),
-		// 	ExecFile:      "/usr/bin/firecracker",
-		// 	JailerBinary:  "/usr/bin/jailer",
-		// 	ChrootBaseDir: "/var/lib/firecracker",
-		// },
-		JailerCfg: nil,
+		JailerCfg: jailerCfg,
+		Snapshot:  opts.Snapshot,
 	}
 
-	ctx := context.Background()
-
 	// Create and configure logrus logger
 	logger := logrus.New()
 	logger.SetOutput(os.Stdout)
@@ -296,19 +380,41 @@ func (vmm *VMManager) StartVM(vmID string) error {
 		FullTimestamp:   true,
 	})
 
-	m, err := firecracker.NewMachine(ctx, cfg, firecracker.WithLogger(logger.WithContext(ctx)))
+	consoleOpt, err := vmm.attachConsole(vm, vmm.config, socketPath)
 	if err != nil {
-		return fmt.Errorf("failed to create machine: %v", err)
+		return nil, fmt.Errorf("failed to attach console: %v", err)
+	}
+
+	m, err := firecracker.NewMachine(ctx, cfg, firecracker.WithLogger(logger.WithContext(ctx)), consoleOpt)
+	if err != nil {
+		vmm.detachConsole(vm.ID)
+		return nil, fmt.Errorf("failed to create machine: %v", err)
 	}
 
 	if err := m.Start(ctx); err != nil {
-		return fmt.Errorf("failed to start machine: %v", err)
+		vmm.detachConsole(vm.ID)
+		return nil, fmt.Errorf("failed to start machine: %v", err)
 	}
 
-	vm.machine = m
-	vm.Status = "running"
+	// The SDK's CNI integration populates StaticConfiguration.IPConfiguration
+	// with the address host-local IPAM assigned once the CNI ADD completes.
+	if iface := cfg.NetworkInterfaces[0]; iface.StaticConfiguration != nil && iface.StaticConfiguration.IPConfiguration != nil {
+		vm.IPAddress = iface.StaticConfiguration.IPConfiguration.IPAddr.IP.String()
+	}
 
-	return nil
+	if len(vm.PortForwards) > 0 && vm.IPAddress != "" {
+		if err := vmm.networkManager.ProgramPortForwards(vm.ID, vm.IPAddress, vm.PortForwards); err != nil {
+			logrus.Warnf("failed to program port forwards for VM %s: %v", vm.ID, err)
+		}
+	}
+
+	if vm.Jailed {
+		if err := applyCgroupLimits(vm, vmm.config.CgroupRoot); err != nil {
+			logrus.Warnf("failed to apply cgroup limits for VM %s: %v", vm.ID, err)
+		}
+	}
+
+	return m, nil
 }
 
 func (vmm *VMManager) StopVM(vmID string) error {
@@ -330,8 +436,15 @@ func (vmm *VMManager) StopVM(vmID string) error {
 		}
 	}
 
+	vmm.detachConsole(vmID)
+	if len(vm.PortForwards) > 0 {
+		vmm.networkManager.TeardownPortForwards(vmID)
+	}
 	vm.Status = "stopped"
 	vm.machine = nil
+	if err := vmm.store.Save(vm); err != nil {
+		logrus.Warnf("failed to persist VM %s: %v", vmID, err)
+	}
 
 	return nil
 }
@@ -349,19 +462,24 @@ func (vmm *VMManager) DeleteVM(vmID string) error {
 	if vm.Status == "running" && vm.machine != nil {
 		vm.machine.Shutdown(context.Background())
 	}
+	vmm.detachConsole(vmID)
+	if len(vm.PortForwards) > 0 {
+		vmm.networkManager.TeardownPortForwards(vmID)
+	}
+	if vm.Jailed {
+		vmm.teardownJail(vm)
+	}
 
-	// Cleanup resources
-	vmm.ipPool.ReleaseIP(vm.IPAddress)
-	vmm.tapManager.ReleaseTap(vm.TapDevice)
-
-	// Remove TAP interface
-	vmm.removeTapInterface(vm.TapDevice)
-
-	// Remove VM directory
-	vmDir := filepath.Dir(vm.SocketPath)
+	// Remove VM directory. Derived from config.VMDir/vmID rather than
+	// vm.SocketPath, since startMachine rewrites that field to the jailer
+	// chroot path (already removed by teardownJail above) for jailed VMs.
+	vmDir := filepath.Join(vmm.config.VMDir, vmID)
 	os.RemoveAll(vmDir)
 
 	delete(vmm.vms, vmID)
+	if err := vmm.store.Delete(vmID); err != nil {
+		logrus.Warnf("failed to remove persisted VM %s: %v", vmID, err)
+	}
 	return nil
 }
 
@@ -573,46 +691,6 @@ func (vmm *VMManager) resizeRootfs(rootfsPath string, sizeGB int) error {
 	return nil
 }
 
-func (vmm *VMManager) createTapInterface(tapName string) error {
-	// Create TAP interface
-	cmd := exec.Command("ip", "tuntap", "add", tapName, "mode", "tap")
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to create TAP interface: %v", err)
-	}
-
-	// Bring interface up
-	upCmd := exec.Command("ip", "link", "set", tapName, "up")
-	if err := upCmd.Run(); err != nil {
-		return fmt.Errorf("failed to bring up TAP interface: %v", err)
-	}
-
-	// Add to bridge
-	bridgeCmd := exec.Command("ip", "link", "set", tapName, "master", vmm.config.NetworkBridge)
-	return bridgeCmd.Run()
-}
-
-func (vmm *VMManager) removeTapInterface(tapName string) error {
-	cmd := exec.Command("ip", "link", "delete", tapName)
-	return cmd.Run()
-}
-
-func (vmm *VMManager) cleanup(vmID, ipAddr, tapDevice string) {
-	vmDir := filepath.Join(vmm.config.VMDir, vmID)
-	os.RemoveAll(vmDir)
-	vmm.ipPool.ReleaseIP(ipAddr)
-	vmm.tapManager.ReleaseTap(tapDevice)
-	vmm.removeTapInterface(tapDevice)
-}
-
-func generateMacAddress() string {
-	// Generate a random MAC address
-	return fmt.Sprintf("02:00:%02x:%02x:%02x:%02x",
-		time.Now().Unix()&0xff,
-		time.Now().Unix()>>8&0xff,
-		time.Now().Unix()>>16&0xff,
-		time.Now().Unix()>>24&0xff)
-}
-
 // API Handlers
 func (vmm *VMManager) createVMHandler(c *gin.Context) {
 	var req VMRequest
@@ -715,6 +793,23 @@ func (vmm *VMManager) deleteVMHandler(c *gin.Context) {
 	})
 }
 
+func (vmm *VMManager) listNetworksHandler(c *gin.Context) {
+	names, err := vmm.networkManager.ListNetworks()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Success: false,
+			Message: fmt.Sprintf("Failed to list networks: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Success: true,
+		Message: "networks retrieved successfully",
+		Data:    names,
+	})
+}
+
 func setupRouter(vmManager *VMManager) *gin.Engine {
 	r := gin.Default()
 
@@ -740,6 +835,14 @@ func setupRouter(vmManager *VMManager) *gin.Engine {
 		api.POST("/vms/:id/start", vmManager.startVMHandler)
 		api.POST("/vms/:id/stop", vmManager.stopVMHandler)
 		api.DELETE("/vms/:id", vmManager.deleteVMHandler)
+		api.GET("/vms/:id/console/logs", vmManager.consoleLogsHandler)
+		api.GET("/vms/:id/console/stream", vmManager.consoleStreamHandler)
+		api.POST("/images/import", vmManager.importImageHandler)
+		api.GET("/images", vmManager.listImagesHandler)
+		api.GET("/networks", vmManager.listNetworksHandler)
+		api.POST("/vms/:id/snapshot", vmManager.snapshotVMHandler)
+		api.POST("/vms/:id/restore", vmManager.restoreVMHandler)
+		api.POST("/vms/:id/clone", vmManager.cloneVMHandler)
 	}
 
 	return r
@@ -747,11 +850,25 @@ func setupRouter(vmManager *VMManager) *gin.Engine {
 
 func main() {
 	config := NewConfig()
-	vmManager := NewVMManager(config)
 
 	// Ensure required directories exist
 	os.MkdirAll(config.VMDir, 0755)
 	os.MkdirAll(config.BaseImagesDir, 0755)
+	os.MkdirAll(config.NetworksDir, 0755)
+
+	vmManager, err := NewVMManager(config)
+	if err != nil {
+		log.Fatalf("Failed to initialize VM manager: %v", err)
+	}
+	defer vmManager.store.Close()
+
+	vmManager.startReconciler(context.Background())
+
+	if imageBuilder, err := NewImageBuilder(config.BaseImagesDir, config.ContainerdSocket); err != nil {
+		log.Printf("Image importer disabled: %v", err)
+	} else {
+		vmManager.imageBuilder = imageBuilder
+	}
 
 	router := setupRouter(vmManager)
 
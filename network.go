@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/firecracker-microvm/firecracker-go-sdk"
+)
+
+// PortMapping exposes a guest port on the host via a DNAT rule once the VM's
+// CNI-assigned IP address is known.
+type PortMapping struct {
+	HostPort  int    `json:"host_port" binding:"required"`
+	GuestPort int    `json:"guest_port" binding:"required"`
+	Protocol  string `json:"protocol,omitempty"` // "tcp" (default) or "udp"
+}
+
+// NetworkManager resolves named CNI networks from a networks.d directory and
+// programs the host-side NAT rules needed to expose guest ports. Per-VM
+// tap/veth plumbing and IPAM are delegated to the CNI plugin chain (ptp,
+// tc-redirect-tap, host-local) invoked by the firecracker SDK itself via
+// firecracker.CNIConfiguration; this manager no longer allocates IPs or TAP
+// devices directly.
+type NetworkManager struct {
+	confDir string
+	binPath []string
+	mutex   sync.Mutex
+}
+
+func NewNetworkManager(confDir string, binPath []string) *NetworkManager {
+	return &NetworkManager{
+		confDir: confDir,
+		binPath: binPath,
+	}
+}
+
+// ListNetworks returns the names of the CNI network lists (*.conflist)
+// defined under confDir, so operators can reference multiple named networks
+// from VMRequest.Network.
+func (nm *NetworkManager) ListNetworks() ([]string, error) {
+	entries, err := os.ReadDir(nm.confDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read networks directory %s: %v", nm.confDir, err)
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".conflist" {
+			continue
+		}
+		names = append(names, strings.TrimSuffix(entry.Name(), ".conflist"))
+	}
+	return names, nil
+}
+
+// NetworkExists reports whether name has a corresponding <name>.conflist
+// under confDir.
+func (nm *NetworkManager) NetworkExists(name string) bool {
+	_, err := os.Stat(filepath.Join(nm.confDir, name+".conflist"))
+	return err == nil
+}
+
+// cniConfiguration builds the firecracker SDK's CNI configuration for a VM on
+// the given named network. The SDK drives the libcni ADD/DEL calls itself
+// (ptp + tc-redirect-tap + host-local, as defined by the conflist) and wires
+// the resulting tap device into the microVM.
+func (nm *NetworkManager) cniConfiguration(vmID, networkName string) *firecracker.CNIConfiguration {
+	return &firecracker.CNIConfiguration{
+		NetworkName: networkName,
+		IfName:      "eth0",
+		VMIfName:    "eth0",
+		ConfDir:     nm.confDir,
+		BinPath:     nm.binPath,
+		CacheDir:    filepath.Join(os.TempDir(), "cni-cache", vmID),
+	}
+}
+
+// portForwardChain names the iptables chain dedicated to one VM's DNAT
+// rules, so cleanup on delete is a single flush + delete rather than
+// tracking individual rules.
+func portForwardChain(vmID string) string {
+	return fmt.Sprintf("FC-DNAT-%s", vmID[:8])
+}
+
+// ProgramPortForwards creates a dedicated DNAT chain for the VM and jumps to
+// it from PREROUTING, so each exposed guest port is reachable at
+// <host>:<HostPort>.
+func (nm *NetworkManager) ProgramPortForwards(vmID, vmIP string, mappings []PortMapping) error {
+	if len(mappings) == 0 {
+		return nil
+	}
+
+	nm.mutex.Lock()
+	defer nm.mutex.Unlock()
+
+	chain := portForwardChain(vmID)
+
+	if err := run("iptables", "-t", "nat", "-N", chain); err != nil {
+		return fmt.Errorf("failed to create NAT chain %s: %v", chain, err)
+	}
+	if err := run("iptables", "-t", "nat", "-A", "PREROUTING", "-j", chain); err != nil {
+		return fmt.Errorf("failed to hook NAT chain %s into PREROUTING: %v", chain, err)
+	}
+
+	for _, m := range mappings {
+		proto := m.Protocol
+		if proto == "" {
+			proto = "tcp"
+		}
+		dest := fmt.Sprintf("%s:%d", vmIP, m.GuestPort)
+		if err := run("iptables", "-t", "nat", "-A", chain,
+			"-p", proto, "--dport", fmt.Sprintf("%d", m.HostPort),
+			"-j", "DNAT", "--to-destination", dest); err != nil {
+			return fmt.Errorf("failed to program DNAT rule %d->%s: %v", m.HostPort, dest, err)
+		}
+	}
+
+	return nil
+}
+
+// TeardownPortForwards removes the VM's DNAT chain and its PREROUTING jump.
+// Only the final chain deletion's error is returned; StopVM and DeleteVM
+// treat this cleanup as best-effort and ignore it.
+func (nm *NetworkManager) TeardownPortForwards(vmID string) error {
+	nm.mutex.Lock()
+	defer nm.mutex.Unlock()
+
+	chain := portForwardChain(vmID)
+
+	run("iptables", "-t", "nat", "-D", "PREROUTING", "-j", chain)
+	run("iptables", "-t", "nat", "-F", chain)
+	return run("iptables", "-t", "nat", "-X", chain)
+}
+
+func run(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s %s failed: %v (output: %s)", name, strings.Join(args, " "), err, string(output))
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/firecracker-microvm/firecracker-go-sdk"
+)
+
+// SecurityConfig controls jailer-based sandboxing for a VM: a dedicated
+// UID/GID, a chroot jail holding the kernel/rootfs, cgroup v2 resource caps,
+// and a seccomp filter. It replaces the previously-commented-out JailerCfg
+// wiring with a first-class, per-request option.
+type SecurityConfig struct {
+	Jailer            bool   `json:"jailer,omitempty"`
+	SeccompFilterPath string `json:"seccomp_filter_path,omitempty"` // overrides Config.SeccompFilterPath
+}
+
+// UIDGIDAllocator hands out unique UID/GID values from a configured range,
+// one per jailed VM.
+type UIDGIDAllocator struct {
+	start, end int
+	allocated  map[int]bool
+	mutex      sync.Mutex
+}
+
+func NewUIDGIDAllocator(start, end int) *UIDGIDAllocator {
+	return &UIDGIDAllocator{
+		start:     start,
+		end:       end,
+		allocated: make(map[int]bool),
+	}
+}
+
+func (a *UIDGIDAllocator) Allocate() (int, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	for id := a.start; id <= a.end; id++ {
+		if !a.allocated[id] {
+			a.allocated[id] = true
+			return id, nil
+		}
+	}
+	return 0, fmt.Errorf("no available UID/GID in range %d-%d", a.start, a.end)
+}
+
+func (a *UIDGIDAllocator) Release(id int) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	delete(a.allocated, id)
+}
+
+// allocateJailerUIDGID assigns vm a fresh UID/GID from the daemon's pool when
+// it is jailed, mirroring CreateVM's allocation so restored and cloned VMs
+// get their own jail identity rather than reusing their source VM's.
+func (vmm *VMManager) allocateJailerUIDGID(vm *VM) error {
+	if !vm.Jailed {
+		return nil
+	}
+
+	uid, err := vmm.uidGidAllocator.Allocate()
+	if err != nil {
+		return fmt.Errorf("failed to allocate jailer UID/GID: %v", err)
+	}
+	vm.UID = uid
+	vm.GID = uid
+	return nil
+}
+
+// jailerChrootDir is where the jailer chroots a VM: <ChrootBaseDir>/<exec
+// file basename>/<vmID>/root. Firecracker itself picks this path, so we
+// compute the same one to know where bind-mounted resources land.
+func jailerChrootDir(chrootBaseDir, execFile, vmID string) string {
+	return filepath.Join(chrootBaseDir, filepath.Base(execFile), vmID, "root")
+}
+
+// prepareJail bind-mounts the kernel, rootfs, and (if present) cloud-init
+// ISO into the jailer's chroot directory and returns the in-chroot paths the
+// SDK should be configured with (the jailer process itself runs chrooted,
+// so these are just the basenames once inside root/). isoInChroot is empty
+// when vm has no cloud-init drive.
+func prepareJail(vm *VM, cfg *Config) (kernelInChroot, rootfsInChroot, socketInChroot, isoInChroot string, err error) {
+	chrootDir := jailerChrootDir(cfg.ChrootBaseDir, cfg.FirecrackerBinary, vm.ID)
+	if err := os.MkdirAll(chrootDir, 0700); err != nil {
+		return "", "", "", "", fmt.Errorf("failed to create jail chroot %s: %v", chrootDir, err)
+	}
+	if err := os.Chown(chrootDir, vm.UID, vm.GID); err != nil {
+		return "", "", "", "", fmt.Errorf("failed to chown jail chroot %s: %v", chrootDir, err)
+	}
+
+	kernelDest := filepath.Join(chrootDir, filepath.Base(vm.KernelPath))
+	if err := bindMountInto(vm.KernelPath, kernelDest); err != nil {
+		return "", "", "", "", fmt.Errorf("failed to bind-mount kernel into jail: %v", err)
+	}
+
+	rootfsDest := filepath.Join(chrootDir, filepath.Base(vm.RootfsPath))
+	if err := bindMountInto(vm.RootfsPath, rootfsDest); err != nil {
+		return "", "", "", "", fmt.Errorf("failed to bind-mount rootfs into jail: %v", err)
+	}
+
+	if vm.CloudInitISOPath != "" {
+		isoDest := filepath.Join(chrootDir, filepath.Base(vm.CloudInitISOPath))
+		if err := bindMountInto(vm.CloudInitISOPath, isoDest); err != nil {
+			return "", "", "", "", fmt.Errorf("failed to bind-mount cloud-init ISO into jail: %v", err)
+		}
+		isoInChroot = filepath.Base(isoDest)
+	}
+
+	if filterPath := seccompFilterPath(vm, cfg); filterPath != "" {
+		if err := copyFileInto(filterPath, filepath.Join(chrootDir, "seccomp-filter.bpf")); err != nil {
+			return "", "", "", "", fmt.Errorf("failed to stage seccomp filter into jail: %v", err)
+		}
+	}
+
+	return filepath.Base(kernelDest), filepath.Base(rootfsDest), "firecracker.socket", isoInChroot, nil
+}
+
+func bindMountInto(src, dst string) error {
+	if _, err := os.Stat(dst); err == nil {
+		return nil
+	}
+	if err := os.WriteFile(dst, nil, 0600); err != nil {
+		return fmt.Errorf("failed to create bind-mount target %s: %v", dst, err)
+	}
+	return run("mount", "--bind", src, dst)
+}
+
+// seccompFilterPath resolves the seccomp-bpf filter vm should be started
+// with, preferring a per-VM override over the daemon-wide default.
+func seccompFilterPath(vm *VM, cfg *Config) string {
+	if vm.Security != nil && vm.Security.SeccompFilterPath != "" {
+		return vm.Security.SeccompFilterPath
+	}
+	return cfg.SeccompFilterPath
+}
+
+func copyFileInto(src, dst string) error {
+	if _, err := os.Stat(src); err != nil {
+		return fmt.Errorf("seccomp filter %s not found: %v", src, err)
+	}
+	return run("cp", src, dst)
+}
+
+// buildJailerConfig returns the firecracker SDK jailer configuration for vm,
+// replacing the disabled JailerCfg: nil that previously shipped here.
+func buildJailerConfig(vm *VM, cfg *Config) firecracker.JailerConfig {
+	return firecracker.JailerConfig{
+		GID:           firecracker.Int(vm.GID),
+		UID:           firecracker.Int(vm.UID),
+		ID:            vm.ID,
+		NumaNode:      firecracker.Int(0),
+		ExecFile:      cfg.FirecrackerBinary,
+		JailerBinary:  cfg.JailerBinary,
+		ChrootBaseDir: cfg.ChrootBaseDir,
+	}
+}
+
+// cgroupPath returns the cgroup v2 directory for a VM's jail.
+func cgroupPath(cgroupRoot, vmID string) string {
+	return filepath.Join(cgroupRoot, "firecracker", vmID)
+}
+
+// applyCgroupLimits derives cpu.max/memory.max from the VM's requested
+// CPU/Memory and writes them to its cgroup v2 directory. io.max is left to
+// operators to tune per-device, since it depends on the host's block device
+// topology.
+func applyCgroupLimits(vm *VM, cgroupRoot string) error {
+	dir := cgroupPath(cgroupRoot, vm.ID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create cgroup %s: %v", dir, err)
+	}
+
+	cpuMax := fmt.Sprintf("%d 100000", vm.CPU*100000)
+	if err := os.WriteFile(filepath.Join(dir, "cpu.max"), []byte(cpuMax), 0644); err != nil {
+		return fmt.Errorf("failed to set cpu.max: %v", err)
+	}
+
+	memMaxBytes := int64(vm.Memory) * 1024 * 1024
+	if err := os.WriteFile(filepath.Join(dir, "memory.max"), []byte(fmt.Sprintf("%d", memMaxBytes)), 0644); err != nil {
+		return fmt.Errorf("failed to set memory.max: %v", err)
+	}
+
+	return nil
+}
+
+// teardownJail removes the cgroup, releases the UID/GID, and unmounts +
+// removes the chroot created for vm.
+func (vmm *VMManager) teardownJail(vm *VM) {
+	if vm.UID == 0 && vm.GID == 0 {
+		return
+	}
+
+	os.RemoveAll(cgroupPath(vmm.config.CgroupRoot, vm.ID))
+
+	chrootDir := jailerChrootDir(vmm.config.ChrootBaseDir, vmm.config.FirecrackerBinary, vm.ID)
+	run("umount", filepath.Join(chrootDir, filepath.Base(vm.KernelPath)))
+	run("umount", filepath.Join(chrootDir, filepath.Base(vm.RootfsPath)))
+	if vm.CloudInitISOPath != "" {
+		run("umount", filepath.Join(chrootDir, filepath.Base(vm.CloudInitISOPath)))
+	}
+	os.RemoveAll(filepath.Join(vmm.config.ChrootBaseDir, filepath.Base(vmm.config.FirecrackerBinary), vm.ID))
+
+	vmm.uidGidAllocator.Release(vm.UID)
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/firecracker-microvm/firecracker-go-sdk"
+	"github.com/firecracker-microvm/firecracker-go-sdk/client/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// Snapshot records a point-in-time Firecracker snapshot of a VM: its paused
+// memory image, the machine/device state, and the rootfs it was taken
+// against (so clone can reflink/copy it independently of the live VM).
+type Snapshot struct {
+	ID         string    `json:"id"`
+	VMID       string    `json:"vm_id"`
+	MemFile    string    `json:"mem_file"`
+	SnapFile   string    `json:"snapshot_file"`
+	RootfsPath string    `json:"rootfs_path"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type snapshotRequest struct {
+	// Name optionally labels the snapshot directory; defaults to a UUID.
+	Name string `json:"name,omitempty"`
+}
+
+type restoreRequest struct {
+	SnapshotID string `json:"snapshot_id" binding:"required"`
+}
+
+type cloneRequest struct {
+	SnapshotID string `json:"snapshot_id" binding:"required"`
+	Name       string `json:"name,omitempty"`
+}
+
+func (vmm *VMManager) snapshotDir(vmID, snapshotID string) string {
+	return filepath.Join(vmm.config.VMDir, vmID, "snapshots", snapshotID)
+}
+
+// CreateSnapshot pauses vm, takes a Firecracker snapshot (memory + machine
+// state), resumes it, and records the result on vm.Snapshots.
+func (vmm *VMManager) CreateSnapshot(vmID string, req snapshotRequest) (*Snapshot, error) {
+	vmm.mutex.Lock()
+	defer vmm.mutex.Unlock()
+
+	vm, exists := vmm.vms[vmID]
+	if !exists {
+		return nil, fmt.Errorf("VM not found")
+	}
+	if vm.Status != "running" || vm.machine == nil {
+		return nil, fmt.Errorf("VM is not running")
+	}
+
+	snapshotID := req.Name
+	if snapshotID == "" {
+		snapshotID = uuid.New().String()
+	}
+
+	dir := vmm.snapshotDir(vmID, snapshotID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create snapshot directory: %v", err)
+	}
+
+	ctx := context.Background()
+
+	if err := vm.machine.PauseVM(ctx); err != nil {
+		return nil, fmt.Errorf("failed to pause VM: %v", err)
+	}
+
+	memFile := filepath.Join(dir, "mem_file")
+	snapFile := filepath.Join(dir, "snapshot_file")
+
+	err := vm.machine.CreateSnapshot(ctx, memFile, snapFile)
+	if resumeErr := vm.machine.ResumeVM(ctx); resumeErr != nil {
+		logrus.Warnf("failed to resume VM %s after snapshot: %v", vmID, resumeErr)
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to create snapshot: %v", err)
+	}
+
+	snap := &Snapshot{
+		ID:         snapshotID,
+		VMID:       vmID,
+		MemFile:    memFile,
+		SnapFile:   snapFile,
+		RootfsPath: vm.RootfsPath,
+		CreatedAt:  time.Now(),
+	}
+	vm.Snapshots = append(vm.Snapshots, *snap)
+
+	if err := vmm.store.Save(vm); err != nil {
+		logrus.Warnf("failed to persist VM %s after snapshot: %v", vmID, err)
+	}
+
+	return snap, nil
+}
+
+// RestoreSnapshot boots a new VM from an existing snapshot's memory and
+// machine state. The source VM's record and process are left as they are,
+// but the new VM boots on snap.RootfsPath, which is the source VM's own
+// rootfs file, not a copy (CloneFromSnapshot copies it). It wires up the same
+// CNI networking, console capture, and jailer sandboxing as a freshly
+// created VM via startMachine.
+func (vmm *VMManager) RestoreSnapshot(sourceVMID, snapshotID string) (*VM, error) {
+	vmm.mutex.Lock()
+	sourceVM, exists := vmm.vms[sourceVMID]
+	if !exists {
+		vmm.mutex.Unlock()
+		return nil, fmt.Errorf("VM not found")
+	}
+	snap, err := findSnapshot(sourceVM, snapshotID)
+	vmm.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	newVMID := uuid.New().String()
+	vmDir := filepath.Join(vmm.config.VMDir, newVMID)
+	if err := os.MkdirAll(vmDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create VM directory: %v", err)
+	}
+
+	newVM := &VM{
+		ID:            newVMID,
+		Name:          fmt.Sprintf("%s-restored", sourceVM.Name),
+		CPU:           sourceVM.CPU,
+		Memory:        sourceVM.Memory,
+		DiskSize:      sourceVM.DiskSize,
+		Image:         sourceVM.Image,
+		Status:        "created",
+		Network:       sourceVM.Network,
+		CreatedAt:     time.Now(),
+		SocketPath:    filepath.Join(vmDir, "firecracker.socket"),
+		KernelPath:    sourceVM.KernelPath,
+		RootfsPath:    snap.RootfsPath,
+		PortForwards:  sourceVM.PortForwards,
+		RestartPolicy: sourceVM.RestartPolicy,
+		Jailed:        sourceVM.Jailed,
+		Security:      sourceVM.Security,
+	}
+
+	if err := vmm.allocateJailerUIDGID(newVM); err != nil {
+		os.RemoveAll(vmDir)
+		return nil, err
+	}
+
+	drives := []models.Drive{
+		{
+			DriveID:      firecracker.String("rootfs"),
+			PathOnHost:   firecracker.String(newVM.RootfsPath),
+			IsRootDevice: firecracker.Bool(true),
+			IsReadOnly:   firecracker.Bool(false),
+		},
+	}
+
+	m, err := vmm.startMachine(context.Background(), newVM, startMachineOpts{
+		Drives: drives,
+		Snapshot: firecracker.SnapshotConfig{
+			MemFilePath:  snap.MemFile,
+			SnapshotPath: snap.SnapFile,
+			ResumeVM:     true,
+		},
+	})
+	if err != nil {
+		os.RemoveAll(vmDir)
+		return nil, fmt.Errorf("failed to restore machine from snapshot: %v", err)
+	}
+
+	vmm.mutex.Lock()
+	defer vmm.mutex.Unlock()
+
+	newVM.machine = m
+	newVM.Status = "running"
+	vmm.vms[newVMID] = newVM
+	if err := vmm.store.Save(newVM); err != nil {
+		logrus.Warnf("failed to persist restored VM %s: %v", newVMID, err)
+	}
+
+	return newVM, nil
+}
+
+// CloneFromSnapshot copies a snapshot's rootfs (using a reflink fast path on
+// btrfs/xfs, falling back to a full copy) and boots a new VM from the
+// resulting snapshot + rootfs pair, without touching the source VM. It wires
+// up the same CNI networking, console capture, and jailer sandboxing as a
+// freshly created VM via startMachine.
+func (vmm *VMManager) CloneFromSnapshot(sourceVMID, snapshotID, name string) (*VM, error) {
+	vmm.mutex.Lock()
+	sourceVM, exists := vmm.vms[sourceVMID]
+	if !exists {
+		vmm.mutex.Unlock()
+		return nil, fmt.Errorf("VM not found")
+	}
+	snap, err := findSnapshot(sourceVM, snapshotID)
+	vmm.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	newVMID := uuid.New().String()
+	vmDir := filepath.Join(vmm.config.VMDir, newVMID)
+	if err := os.MkdirAll(vmDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create VM directory: %v", err)
+	}
+
+	clonedRootfs := filepath.Join(vmDir, "rootfs.ext4")
+	if err := reflinkOrCopy(snap.RootfsPath, clonedRootfs); err != nil {
+		os.RemoveAll(vmDir)
+		return nil, fmt.Errorf("failed to clone rootfs: %v", err)
+	}
+
+	if name == "" {
+		name = fmt.Sprintf("%s-clone", sourceVM.Name)
+	}
+
+	newVM := &VM{
+		ID:            newVMID,
+		Name:          name,
+		CPU:           sourceVM.CPU,
+		Memory:        sourceVM.Memory,
+		DiskSize:      sourceVM.DiskSize,
+		Image:         sourceVM.Image,
+		Status:        "created",
+		Network:       sourceVM.Network,
+		CreatedAt:     time.Now(),
+		SocketPath:    filepath.Join(vmDir, "firecracker.socket"),
+		KernelPath:    sourceVM.KernelPath,
+		RootfsPath:    clonedRootfs,
+		PortForwards:  sourceVM.PortForwards,
+		RestartPolicy: sourceVM.RestartPolicy,
+		Jailed:        sourceVM.Jailed,
+		Security:      sourceVM.Security,
+	}
+
+	if err := vmm.allocateJailerUIDGID(newVM); err != nil {
+		os.RemoveAll(vmDir)
+		return nil, err
+	}
+
+	drives := []models.Drive{
+		{
+			DriveID:      firecracker.String("rootfs"),
+			PathOnHost:   firecracker.String(newVM.RootfsPath),
+			IsRootDevice: firecracker.Bool(true),
+			IsReadOnly:   firecracker.Bool(false),
+		},
+	}
+
+	m, err := vmm.startMachine(context.Background(), newVM, startMachineOpts{
+		Drives: drives,
+		Snapshot: firecracker.SnapshotConfig{
+			MemFilePath:  snap.MemFile,
+			SnapshotPath: snap.SnapFile,
+			ResumeVM:     true,
+		},
+	})
+	if err != nil {
+		os.RemoveAll(vmDir)
+		return nil, fmt.Errorf("failed to start cloned machine: %v", err)
+	}
+
+	vmm.mutex.Lock()
+	defer vmm.mutex.Unlock()
+
+	newVM.machine = m
+	newVM.Status = "running"
+	vmm.vms[newVMID] = newVM
+	if err := vmm.store.Save(newVM); err != nil {
+		logrus.Warnf("failed to persist cloned VM %s: %v", newVMID, err)
+	}
+
+	return newVM, nil
+}
+
+func findSnapshot(vm *VM, snapshotID string) (*Snapshot, error) {
+	for i := range vm.Snapshots {
+		if vm.Snapshots[i].ID == snapshotID {
+			return &vm.Snapshots[i], nil
+		}
+	}
+	return nil, fmt.Errorf("snapshot %q not found for VM %s", snapshotID, vm.ID)
+}
+
+// reflinkOrCopy clones src to dst using FICLONE on reflink-capable
+// filesystems (btrfs, xfs) via `cp --reflink=auto`, so a multi-GB rootfs
+// clone completes in milliseconds. cp transparently falls back to a regular
+// byte-for-byte copy when the filesystem doesn't support reflinks, so no
+// separate slow-path implementation is needed here.
+func reflinkOrCopy(src, dst string) error {
+	cmd := exec.Command("cp", "--reflink=auto", src, dst)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("cp --reflink=auto failed: %v (output: %s)", err, string(output))
+	}
+	return nil
+}
+
+// API Handlers
+
+func (vmm *VMManager) snapshotVMHandler(c *gin.Context) {
+	vmID := c.Param("id")
+
+	var req snapshotRequest
+	// Body is optional; ignore bind errors from an empty body.
+	_ = c.ShouldBindJSON(&req)
+
+	snap, err := vmm.CreateSnapshot(vmID, req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Success: false,
+			Message: fmt.Sprintf("Failed to snapshot VM: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, Response{
+		Success: true,
+		Message: "snapshot created successfully",
+		Data:    snap,
+	})
+}
+
+func (vmm *VMManager) restoreVMHandler(c *gin.Context) {
+	vmID := c.Param("id")
+
+	var req restoreRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Success: false,
+			Message: fmt.Sprintf("Invalid request: %v", err),
+		})
+		return
+	}
+
+	vm, err := vmm.RestoreSnapshot(vmID, req.SnapshotID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Success: false,
+			Message: fmt.Sprintf("Failed to restore VM: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, Response{
+		Success: true,
+		Message: "VM restored successfully",
+		Data:    vm,
+	})
+}
+
+func (vmm *VMManager) cloneVMHandler(c *gin.Context) {
+	vmID := c.Param("id")
+
+	var req cloneRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Success: false,
+			Message: fmt.Sprintf("Invalid request: %v", err),
+		})
+		return
+	}
+
+	vm, err := vmm.CloneFromSnapshot(vmID, req.SnapshotID, req.Name)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Success: false,
+			Message: fmt.Sprintf("Failed to clone VM: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, Response{
+		Success: true,
+		Message: "VM cloned successfully",
+		Data:    vm,
+	})
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+var vmsBucket = []byte("vms")
+
+// VMStore persists VM records across process restarts so a daemon restart
+// doesn't lose track of running microVMs (and leak their TAP/CNI/IP
+// allocations).
+type VMStore struct {
+	db *bolt.DB
+}
+
+func NewVMStore(path string) (*VMStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open VM store at %s: %v", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(vmsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize VM store bucket: %v", err)
+	}
+
+	return &VMStore{db: db}, nil
+}
+
+func (s *VMStore) Save(vm *VM) error {
+	data, err := json.Marshal(vm)
+	if err != nil {
+		return fmt.Errorf("failed to marshal VM %s: %v", vm.ID, err)
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(vmsBucket).Put([]byte(vm.ID), data)
+	})
+}
+
+func (s *VMStore) Delete(vmID string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(vmsBucket).Delete([]byte(vmID))
+	})
+}
+
+// LoadAll returns every VM record persisted in the store, e.g. to reload
+// tracking state on daemon startup.
+func (s *VMStore) LoadAll() ([]*VM, error) {
+	var vms []*VM
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(vmsBucket).ForEach(func(_, data []byte) error {
+			var vm VM
+			if err := json.Unmarshal(data, &vm); err != nil {
+				return fmt.Errorf("failed to unmarshal VM record: %v", err)
+			}
+			vms = append(vms, &vm)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return vms, nil
+}
+
+func (s *VMStore) Close() error {
+	return s.db.Close()
+}
+
+// reconcileInterval is how often the background reconciler probes running
+// VMs for liveness.
+const reconcileInterval = 10 * time.Second
+
+// probeSocket attempts to reach a Firecracker API socket and reports whether
+// the machine behind it is still responsive.
+func probeSocket(socketPath string) bool {
+	client := http.Client{
+		Timeout: 2 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return net.Dial("unix", socketPath)
+			},
+		},
+	}
+
+	resp, err := client.Get("http://unix/")
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return true
+}
+
+// reloadVMs restores vmm.vms from the persistent store. No network
+// allocation is re-registered here: NetworkManager keeps no per-VM IP or
+// TAP state, since IPAM is delegated to the CNI plugin chain. It does not
+// probe liveness; that happens on the first reconciliation tick.
+func (vmm *VMManager) reloadVMs() error {
+	vms, err := vmm.store.LoadAll()
+	if err != nil {
+		return fmt.Errorf("failed to load persisted VMs: %v", err)
+	}
+
+	vmm.mutex.Lock()
+	defer vmm.mutex.Unlock()
+
+	for _, vm := range vms {
+		vmm.vms[vm.ID] = vm
+		logrus.Infof("reloaded VM %s (%s) from store, status=%s", vm.ID, vm.Name, vm.Status)
+	}
+
+	return nil
+}
+
+// startReconciler runs a background goroutine that periodically probes
+// every VM believed to be running. VMs whose Firecracker API socket has
+// stopped responding are transitioned to "crashed"; VMs with
+// RestartPolicy "always" are then automatically restarted.
+func (vmm *VMManager) startReconciler(ctx context.Context) {
+	ticker := time.NewTicker(reconcileInterval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				vmm.reconcileOnce()
+			}
+		}
+	}()
+}
+
+func (vmm *VMManager) reconcileOnce() {
+	vmm.mutex.RLock()
+	var running []*VM
+	for _, vm := range vmm.vms {
+		if vm.Status == "running" {
+			running = append(running, vm)
+		}
+	}
+	vmm.mutex.RUnlock()
+
+	for _, vm := range running {
+		if probeSocket(vm.SocketPath) {
+			continue
+		}
+
+		logrus.Warnf("VM %s is unresponsive, marking crashed", vm.ID)
+
+		// Detach the console buffer registered for the crashed process so a
+		// restart's attachConsole doesn't overwrite the map entry and leak
+		// the old buffer's open log file descriptor.
+		vmm.detachConsole(vm.ID)
+
+		vmm.mutex.Lock()
+		vm.Status = "crashed"
+		vm.machine = nil
+		restartPolicy := vm.RestartPolicy
+		vmm.mutex.Unlock()
+
+		if err := vmm.store.Save(vm); err != nil {
+			logrus.Warnf("failed to persist crashed state for VM %s: %v", vm.ID, err)
+		}
+
+		if restartPolicy == "always" {
+			logrus.Infof("restarting VM %s per restart policy", vm.ID)
+			if err := vmm.StartVM(vm.ID); err != nil {
+				logrus.Warnf("failed to auto-restart VM %s: %v", vm.ID, err)
+			}
+		}
+	}
+}